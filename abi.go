@@ -0,0 +1,84 @@
+package main
+
+import (
+	"debug/buildinfo"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// abi identifies the calling convention used to pass arguments to Go
+// functions in a target binary.
+type abi int
+
+const (
+	abiStack    abi = iota // pre-1.17: arguments on the stack
+	abiRegister            // 1.17+: arguments in registers (ABIInternal)
+)
+
+// detectABI inspects the Go build version embedded in binPath and reports
+// which calling convention its compiled code uses. Go switched from the
+// stack-based ABI0 convention to the register-based ABIInternal convention
+// for amd64 in 1.17 and arm64 shortly after; both archs register-pass from
+// Go 1.18 onward.
+func detectABI(binPath string) (abi, error) {
+	info, err := buildinfo.ReadFile(binPath)
+	if err != nil {
+		return abiStack, fmt.Errorf("reading build info from %s: %w", binPath, err)
+	}
+
+	major, minor, ok := parseGoVersion(info.GoVersion)
+	if !ok {
+		return abiStack, fmt.Errorf("unrecognized Go version %q in %s", info.GoVersion, binPath)
+	}
+
+	goarch := buildSetting(info, "GOARCH", runtime.GOARCH)
+
+	switch goarch {
+	case "arm64":
+		if major > 1 || (major == 1 && minor >= 18) {
+			return abiRegister, nil
+		}
+	default: // amd64 and anything else we don't special-case
+		if major > 1 || (major == 1 && minor >= 17) {
+			return abiRegister, nil
+		}
+	}
+	return abiStack, nil
+}
+
+// parseGoVersion extracts the major/minor version from a string like
+// "go1.21.3". devel builds ("go1.22-abcdef") are treated as the named
+// release.
+func parseGoVersion(v string) (major, minor int, ok bool) {
+	v = strings.TrimPrefix(v, "go")
+	if i := strings.IndexAny(v, "-+ "); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// buildSetting looks up a -ldflags/-gcflags style build setting recorded in
+// info, falling back to def when it isn't present (older binaries don't
+// record GOARCH explicitly since it's implied by the file format).
+func buildSetting(info *buildinfo.BuildInfo, key, def string) string {
+	for _, s := range info.Settings {
+		if s.Key == key {
+			return s.Value
+		}
+	}
+	return def
+}
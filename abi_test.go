@@ -0,0 +1,49 @@
+package main
+
+import (
+	"debug/buildinfo"
+	"testing"
+)
+
+func TestParseGoVersion(t *testing.T) {
+	tests := []struct {
+		in        string
+		wantMajor int
+		wantMinor int
+		wantOK    bool
+	}{
+		{"go1.21.3", 1, 21, true},
+		{"go1.17", 1, 17, true},
+		{"go1.22-abcdef", 1, 22, true},
+		{"go1.16beta1", 1, 16, false}, // "16beta1" doesn't parse as an int
+		{"devel go1.23", 0, 0, false}, // no "go" prefix to trim first
+		{"", 0, 0, false},
+		{"go1", 0, 0, false},
+		{"go1.22.0 +auto", 1, 22, true},
+	}
+	for _, tc := range tests {
+		major, minor, ok := parseGoVersion(tc.in)
+		if ok != tc.wantOK || (ok && (major != tc.wantMajor || minor != tc.wantMinor)) {
+			t.Errorf("parseGoVersion(%q) = (%d, %d, %v), want (%d, %d, %v)",
+				tc.in, major, minor, ok, tc.wantMajor, tc.wantMinor, tc.wantOK)
+		}
+	}
+}
+
+func TestBuildSetting(t *testing.T) {
+	info := &buildinfo.BuildInfo{
+		Settings: []buildinfo.BuildSetting{
+			{Key: "GOARCH", Value: "arm64"},
+			{Key: "GOOS", Value: "linux"},
+		},
+	}
+	if got := buildSetting(info, "GOARCH", "amd64"); got != "arm64" {
+		t.Errorf("buildSetting(GOARCH) = %q, want arm64", got)
+	}
+	if got := buildSetting(info, "GOOS", "amd64"); got != "linux" {
+		t.Errorf("buildSetting(GOOS) = %q, want linux", got)
+	}
+	if got := buildSetting(info, "GOAMD64", "v1"); got != "v1" {
+		t.Errorf("buildSetting(GOAMD64) with missing key = %q, want fallback v1", got)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// target is one (binary, symbol) pair to attach a probe to.
+type target struct {
+	Bin    string
+	Symbol string
+}
+
+// discoverTargets resolves the binaries a cgroup's member processes are
+// running, so a user can point gohttpsnoop at
+// "/sys/fs/cgroup/system.slice/myapp.service" and have it find every Go
+// HTTP server in that slice without naming binaries or symbols manually.
+// Every discovered binary is paired with symbol.
+func discoverTargets(cgroupPath, symbol string) ([]target, error) {
+	pids, err := cgroupPIDs(cgroupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var targets []target
+	for _, pid := range pids {
+		bin, err := binaryForPID(pid)
+		if err != nil {
+			continue // process exited between listing and readlink, or is a kernel thread
+		}
+		if seen[bin] {
+			continue
+		}
+		seen[bin] = true
+		targets = append(targets, target{Bin: bin, Symbol: symbol})
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no running binaries found in cgroup %s", cgroupPath)
+	}
+	return targets, nil
+}
+
+// cgroupPIDs returns the PIDs currently in the cgroup at path, supporting
+// both cgroup v2 (cgroup.procs) and cgroup v1 (tasks).
+func cgroupPIDs(path string) ([]int, error) {
+	for _, name := range []string{"cgroup.procs", "tasks"} {
+		data, err := os.ReadFile(filepath.Join(path, name))
+		if err != nil {
+			continue
+		}
+		var pids []int
+		for _, f := range strings.Fields(string(data)) {
+			pid, err := strconv.Atoi(f)
+			if err != nil {
+				continue
+			}
+			pids = append(pids, pid)
+		}
+		return pids, nil
+	}
+	return nil, fmt.Errorf("%s does not look like a cgroup (no cgroup.procs or tasks file)", path)
+}
+
+// cgroupID returns the cgroup v2 ID for path: the inode number of the
+// cgroup directory, which is exactly what bpf_get_current_cgroup_id()
+// returns in-kernel. We filter this way rather than with BPF_CGROUP_ARRAY
+// because that map type is only consulted by cgroup/skb-attached program
+// types, not by the uprobes gohttpsnoop uses.
+func cgroupID(path string) (uint64, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, fmt.Errorf("stat %s: %w", path, err)
+	}
+	return st.Ino, nil
+}
+
+// binaryForPID resolves the executable backing a running process.
+func binaryForPID(pid int) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+}
+
+// cgroupPollInterval is how often watchCgroup re-reads cgroup.procs/tasks
+// to notice new members.
+const cgroupPollInterval = 2 * time.Second
+
+// watchCgroup calls onChange whenever cgroup membership at path changes.
+// There's no inotify event for "a new PID joined an already-populated
+// cgroup": cgroup.events only toggles on the 0<->1 populated transition
+// (and on freeze), so a server started into a slice that already has
+// something running in it would never be noticed that way. Polling
+// cgroup.procs (falling back to tasks on cgroup v1) is the only approach
+// that reliably catches that case, so that's what we do here.
+func watchCgroup(path string, onChange func()) error {
+	prev, err := cgroupPIDs(path)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(cgroupPollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			cur, err := cgroupPIDs(path)
+			if err != nil {
+				return
+			}
+			if !samePIDs(prev, cur) {
+				prev = cur
+				onChange()
+			}
+		}
+	}()
+	return nil
+}
+
+// samePIDs reports whether a and b contain the same PIDs, ignoring order.
+func samePIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[int]int, len(a))
+	for _, pid := range a {
+		set[pid]++
+	}
+	for _, pid := range b {
+		set[pid]--
+	}
+	for _, n := range set {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestSamePIDs(t *testing.T) {
+	tests := []struct {
+		a, b []int
+		want bool
+	}{
+		{nil, nil, true},
+		{[]int{1, 2, 3}, []int{1, 2, 3}, true},
+		{[]int{1, 2, 3}, []int{3, 2, 1}, true},
+		{[]int{1, 2}, []int{1, 2, 3}, false},
+		{[]int{1, 2, 3}, []int{1, 2, 4}, false},
+		{[]int{1, 1, 2}, []int{1, 2, 2}, false}, // same set, different multiset
+	}
+	for _, tc := range tests {
+		if got := samePIDs(tc.a, tc.b); got != tc.want {
+			t.Errorf("samePIDs(%v, %v) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
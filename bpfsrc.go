@@ -0,0 +1,343 @@
+package main
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+// writeHeaderSymbol and finishRequestSymbol are the net/http internals we
+// correlate against the handler call to learn the response status and the
+// number of bytes written. They're unexported, but stable enough across
+// releases to resolve with symTable rather than ask the user for them.
+const (
+	writeHeaderSymbol   = "net/http.(*response).WriteHeader"
+	finishRequestSymbol = "net/http.(*response).finishRequest"
+)
+
+// maxHeaders caps how many --header values a single run can capture, to
+// keep struct event a fixed, known size.
+const maxHeaders = 4
+
+// sourceTemplate is the full probe set for one target: a uprobe on the
+// user's HandlerFunc entry point that captures request fields and
+// stashes them in reqs keyed by pid_tgid; a uprobe on
+// (*response).WriteHeader that fills in the status code; and a uprobe on
+// (*response).finishRequest that fills in bytes written and emits the
+// consolidated event.
+//
+// KNOWN LIMITATION: pid_tgid is the OS thread ID, not a handle on the Go
+// goroutine running the request. The Go scheduler is free to resume a
+// parked goroutine on a different thread after any blocking syscall or
+// netpoll wait -- reading the request body or flushing the response both
+// qualify -- so on_write_header/on_finish_request can fire on a thread
+// that never ran handler() for this request. When that happens their
+// reqs.lookup() misses, e is silently dropped, and no event is
+// emitted for that request. There's no cheaper correlation key available
+// at handler entry (the *response pointer the caller will use isn't
+// constructed yet), so this is a real, silent drop under any handler
+// that does blocking I/O -- not just a demo simplification.
+//
+// %[1]d/%[2]d/%[3]d gate the compiled-in pid/cgroup/comm filter checks.
+// %[4]-[10] are the net/http.Request and net/url.URL field byte offsets
+// resolved from the target's DWARF info by resolveRequestFieldOffsets.
+// %[11]s/%[12]s/%[13]s are filled in per calling convention: loading
+// *http.Request in the entry probe, the WriteHeader code argument, and
+// the finishRequest receiver. %[14]s is the (possibly
+// empty) block of match_header() calls for -header values. %[15]d is the
+// net/http.response.written field offset, resolved the same way as the
+// Request/URL offsets instead of hand-counted.
+const sourceTemplate = `
+#include <uapi/linux/ptrace.h>
+
+#define OFFSET(ptr, offset) (void*)ptr + offset * 8
+#define FIELD(ptr, off) (void*)((char*)(ptr) + (off))
+
+#define FILTER_PID     %[1]d
+#define FILTER_CGROUP  %[2]d
+#define FILTER_COMM    %[3]d
+
+#define OFF_METHOD      %[4]d
+#define OFF_URL         %[5]d
+#define OFF_HOST        %[6]d
+#define OFF_REMOTE_ADDR %[7]d
+#define OFF_PROTO       %[8]d
+#define OFF_HEADER      %[9]d
+#define OFF_URL_PATH    %[10]d
+#define OFF_WRITTEN     %[15]d
+
+#define MAX_HEADER_KEY 40
+#define MAX_HEADER_VAL 128
+
+struct event {
+	u64  method_len;
+	u64  path_len;
+	char method[10];
+	char path[128];
+	char host[64];
+	u64  host_len;
+	char remote_addr[64];
+	u64  remote_addr_len;
+	char proto[16];
+	u64  proto_len;
+	char header0[MAX_HEADER_VAL];
+	u64  header0_len;
+	char header1[MAX_HEADER_VAL];
+	u64  header1_len;
+	char header2[MAX_HEADER_VAL];
+	u64  header2_len;
+	char header3[MAX_HEADER_VAL];
+	u64  header3_len;
+	char comm[16];
+	u32  pid;
+	s32  status;
+	u64  start_ns;
+	u64  latency_ns;
+	s64  written;
+} __attribute__((packed));
+struct comm_key_t {
+	char comm[16];
+};
+BPF_PERF_OUTPUT(events);
+BPF_HASH(reqs, u64, struct event);
+BPF_HASH(allowed_pids, u32, u8);
+BPF_HASH(allowed_cgroups, u64, u8);
+BPF_HASH(allowed_comm, struct comm_key_t, u8);
+
+static inline int filtered_out()
+{
+#if FILTER_PID
+	u32 pid = bpf_get_current_pid_tgid() >> 32;
+	if (allowed_pids.lookup(&pid) == 0)
+		return 1;
+#endif
+#if FILTER_CGROUP
+	u64 cgroup = bpf_get_current_cgroup_id();
+	if (allowed_cgroups.lookup(&cgroup) == 0)
+		return 1;
+#endif
+#if FILTER_COMM
+	struct comm_key_t key = {};
+	bpf_get_current_comm(&key.comm, sizeof(key.comm));
+	if (allowed_comm.lookup(&key) == 0)
+		return 1;
+#endif
+	return 0;
+}
+
+// read_go_string reads a Go string header (ptr, len at off within base)
+// into out, truncating at outsz.
+static inline void read_go_string(void *base, u64 off, char *out, u64 outsz, u64 *out_len)
+{
+	u64 ptr, len;
+	bpf_probe_read(&ptr, sizeof(ptr), FIELD(base, off));
+	bpf_probe_read(&len, sizeof(len), FIELD(base, off + 8));
+	*out_len = len > outsz ? outsz : len;
+	bpf_probe_read(out, *out_len, (void*)ptr);
+}
+
+// match_header does a best-effort lookup of want in a map[string][]string
+// header map, writing the first value string found into out/out_len. It
+// only inspects the map's first bucket (bmap layout: 8 tophash bytes,
+// then 8 packed string keys, then 8 packed []string values) and gives up
+// if the map has grown past one bucket (hmap.B != 0); overflow buckets
+// are not followed -- correctly resolving Go's hash for arbitrary keys isn't
+// practical from a probe, but real requests almost never carry enough
+// headers to grow past 8 entries.
+static inline void match_header(void *header_map, const char *want, int want_len, char *out, u64 outsz, u64 *out_len)
+{
+	if (header_map == 0)
+		return;
+
+	u8 b;
+	bpf_probe_read(&b, sizeof(b), FIELD(header_map, 9)); // hmap.B
+	if (b != 0)
+		return;
+
+	u64 buckets;
+	bpf_probe_read(&buckets, sizeof(buckets), FIELD(header_map, 16)); // hmap.buckets
+	if (buckets == 0)
+		return;
+
+#pragma unroll
+	for (int i = 0; i < 8; i++) {
+		u64 key_ptr, key_len;
+		bpf_probe_read(&key_ptr, sizeof(key_ptr), FIELD(buckets, 8 + i*16));
+		bpf_probe_read(&key_len, sizeof(key_len), FIELD(buckets, 8 + i*16 + 8));
+		if (key_ptr == 0 || key_len != want_len)
+			continue;
+
+		char key[MAX_HEADER_KEY] = {};
+		u64 keyread = key_len > sizeof(key) ? sizeof(key) : key_len;
+		bpf_probe_read(&key, keyread, (void*)key_ptr);
+
+		int match = 1;
+#pragma unroll
+		for (int c = 0; c < MAX_HEADER_KEY; c++) {
+			if (c >= want_len)
+				break;
+			if (key[c] != want[c]) {
+				match = 0;
+				break;
+			}
+		}
+		if (!match)
+			continue;
+
+		u64 val_ptr;
+		bpf_probe_read(&val_ptr, sizeof(val_ptr), FIELD(buckets, 8 + 128 + i*24)); // values[i], a []string
+		if (val_ptr == 0)
+			return;
+
+		read_go_string((void*)val_ptr, 0, out, outsz, out_len);
+		return;
+	}
+}
+
+int handler(struct pt_regs *ctx)
+{
+	if (filtered_out())
+		return 0;
+
+	u64 pid_tgid = bpf_get_current_pid_tgid();
+	struct event e = {};
+	e.pid = pid_tgid >> 32;
+	e.start_ns = bpf_ktime_get_ns();
+	bpf_get_current_comm(&e.comm, sizeof(e.comm));
+
+%[11]s
+	u64 data;
+
+	// method
+	bpf_probe_read(&data, sizeof(data), FIELD(req, OFF_METHOD));
+	bpf_probe_read(&e.method_len, sizeof(e.method_len), FIELD(req, OFF_METHOD + 8));
+	bpf_probe_read(&e.method,
+		e.method_len > sizeof(e.method) ? sizeof(e.method) : e.method_len,
+		(void*)data);
+
+	// path
+	u64 url;
+	bpf_probe_read(&url, sizeof(url), FIELD(req, OFF_URL));
+	bpf_probe_read(&data, sizeof(data), FIELD(url, OFF_URL_PATH));
+	bpf_probe_read(&e.path_len, sizeof(e.path_len), FIELD(url, OFF_URL_PATH + 8));
+	bpf_probe_read(&e.path,
+		e.path_len > sizeof(e.path) ? sizeof(e.path) : e.path_len,
+		(void*)data);
+
+	read_go_string(req, OFF_HOST, e.host, sizeof(e.host), &e.host_len);
+	read_go_string(req, OFF_REMOTE_ADDR, e.remote_addr, sizeof(e.remote_addr), &e.remote_addr_len);
+	read_go_string(req, OFF_PROTO, e.proto, sizeof(e.proto), &e.proto_len);
+
+	void *header;
+	bpf_probe_read(&header, sizeof(header), FIELD(req, OFF_HEADER));
+%[14]s
+	reqs.update(&pid_tgid, &e);
+	return 0;
+}
+
+int on_write_header(struct pt_regs *ctx)
+{
+	u64 pid_tgid = bpf_get_current_pid_tgid();
+	struct event *e = reqs.lookup(&pid_tgid);
+	if (e == 0)
+		return 0;
+
+%[12]s
+	e->status = code;
+	return 0;
+}
+
+int on_finish_request(struct pt_regs *ctx)
+{
+	u64 pid_tgid = bpf_get_current_pid_tgid();
+	struct event *e = reqs.lookup(&pid_tgid);
+	if (e == 0)
+		return 0;
+
+%[13]s
+	s64 written;
+	bpf_probe_read(&written, sizeof(written), FIELD(resp, OFF_WRITTEN));
+	e->written = written;
+	e->latency_ns = bpf_ktime_get_ns() - e->start_ns;
+
+	events.perf_submit(ctx, e, sizeof(*e));
+	reqs.delete(&pid_tgid);
+	return 0;
+}
+`
+
+const writeHeaderArgsFromStackABI = `	s32 code;
+	bpf_probe_read(&code, sizeof(code), OFFSET(PT_REGS_SP(ctx), 2));
+`
+
+const writeHeaderArgsFromRegisterABIAmd64 = `	s32 code = (s32)ctx->bx;
+`
+
+const writeHeaderArgsFromRegisterABIArm64 = `	s32 code = (s32)ctx->regs[1];
+`
+
+const finishRequestRecvFromStackABI = `	u64 resp;
+	bpf_probe_read(&resp, sizeof(resp), OFFSET(PT_REGS_SP(ctx), 1));
+`
+
+const finishRequestRecvFromRegisterABIAmd64 = `	u64 resp = ctx->ax;
+`
+
+const finishRequestRecvFromRegisterABIArm64 = `	u64 resp = ctx->regs[0];
+`
+
+// headerMatchCalls renders the match_header() calls for the requested
+// -header names, writing results into event.header0.. in order. Names
+// are canonicalized the same way net/http does before storing them in
+// Request.Header, since that's the form match_header will find in the
+// target's header map -- without this, "-header X-Request-ID" would
+// never match the stored "X-Request-Id" key.
+func headerMatchCalls(headers []string) string {
+	fields := []string{"header0", "header1", "header2", "header3"}
+	var b strings.Builder
+	for i, h := range headers {
+		if i >= maxHeaders {
+			break
+		}
+		canon := textproto.CanonicalMIMEHeaderKey(h)
+		fmt.Fprintf(&b, "\tmatch_header(header, %q, %d, e.%s, sizeof(e.%s), &e.%s_len);\n",
+			canon, len(canon), fields[i], fields[i], fields[i])
+	}
+	return b.String()
+}
+
+// buildSource assembles the trace probe set for the target's calling
+// convention, architecture and request-field offsets, compiling in the
+// filter and header-capture checks that cfg requires.
+func buildSource(a abi, goarch string, cfg filterConfig, off *requestFieldOffsets, headers []string) (string, error) {
+	pidFilter, cgroupFilter, commFilter := 0, 0, 0
+	if len(cfg.pids) > 0 {
+		pidFilter = 1
+	}
+	if cfg.cgroupPath != "" {
+		cgroupFilter = 1
+	}
+	if cfg.comm != "" {
+		commFilter = 1
+	}
+
+	var reqSnip, writeHeaderSnip, finishRequestSnip string
+	if a == abiStack {
+		reqSnip, writeHeaderSnip, finishRequestSnip = reqFromStackABI, writeHeaderArgsFromStackABI, finishRequestRecvFromStackABI
+	} else {
+		switch goarch {
+		case "amd64":
+			reqSnip, writeHeaderSnip, finishRequestSnip = reqFromRegisterABIAmd64, writeHeaderArgsFromRegisterABIAmd64, finishRequestRecvFromRegisterABIAmd64
+		case "arm64":
+			reqSnip, writeHeaderSnip, finishRequestSnip = reqFromRegisterABIArm64, writeHeaderArgsFromRegisterABIArm64, finishRequestRecvFromRegisterABIArm64
+		default:
+			return "", fmt.Errorf("register ABI probe not implemented for GOARCH=%s", goarch)
+		}
+	}
+
+	return fmt.Sprintf(sourceTemplate,
+		pidFilter, cgroupFilter, commFilter,
+		off.Method, off.URL, off.Host, off.RemoteAddr, off.Proto, off.Header, off.URLPath,
+		reqSnip, writeHeaderSnip, finishRequestSnip,
+		headerMatchCalls(headers), off.Written), nil
+}
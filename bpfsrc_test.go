@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHeaderMatchCalls(t *testing.T) {
+	got := headerMatchCalls([]string{"X-Request-ID", "authorization"})
+	if !strings.Contains(got, `match_header(header, "X-Request-Id", 12, e.header0, sizeof(e.header0), &e.header0_len);`) {
+		t.Errorf("headerMatchCalls did not canonicalize X-Request-ID, got:\n%s", got)
+	}
+	if !strings.Contains(got, `match_header(header, "Authorization", 13, e.header1, sizeof(e.header1), &e.header1_len);`) {
+		t.Errorf("headerMatchCalls did not canonicalize authorization, got:\n%s", got)
+	}
+
+	// more than maxHeaders is silently capped, not an error.
+	many := make([]string, maxHeaders+2)
+	for i := range many {
+		many[i] = "X-Extra"
+	}
+	got = headerMatchCalls(many)
+	if n := strings.Count(got, "match_header("); n != maxHeaders {
+		t.Errorf("headerMatchCalls emitted %d calls for %d headers, want capped at %d", n, len(many), maxHeaders)
+	}
+}
+
+func TestBuildSource(t *testing.T) {
+	off := &requestFieldOffsets{
+		Method: 8, URL: 16, Host: 80, RemoteAddr: 96, Proto: 112, Header: 48, URLPath: 24, Written: 112,
+	}
+	cfg := filterConfig{pids: []int{1234}}
+
+	src, err := buildSource(abiRegister, "amd64", cfg, off, nil)
+	if err != nil {
+		t.Fatalf("buildSource: %s", err)
+	}
+	for _, want := range []string{
+		"#define FILTER_PID     1",
+		"#define FILTER_CGROUP  0",
+		"#define OFF_METHOD      8",
+		"#define OFF_WRITTEN     112",
+		"u64 req = ctx->cx;",
+		"s32 code = (s32)ctx->bx;",
+		"u64 resp = ctx->ax;",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("buildSource(amd64 register ABI) missing %q in:\n%s", want, src)
+		}
+	}
+
+	if _, err := buildSource(abiRegister, "riscv64", cfg, off, nil); err == nil {
+		t.Error("buildSource with an unimplemented register-ABI GOARCH should return an error")
+	}
+}
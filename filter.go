@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	bpf "github.com/iovisor/gobpf/bcc"
+)
+
+// filterConfig narrows which processes' calls are captured. The
+// zero-value matches everything.
+//
+// pids is filter-only: it's pushed into allowed_pids and consulted by
+// filtered_out(), it does not discover or attach to anything. In
+// particular it does not walk /proc to find or attach to a given PID's
+// children -- only --cgroup-path expands to multiple binaries (via
+// discoverTargets/watchCgroup). A --pid/--pids value that never appears
+// in the attached target's own pid_tgid will just filter everything out.
+type filterConfig struct {
+	pids       []int
+	cgroupPath string
+	comm       string
+}
+
+// parsePIDList parses a comma-separated --pids value.
+func parsePIDList(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var pids []int
+	for _, f := range strings.Split(s, ",") {
+		pid, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %q: %w", f, err)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// applyFilters populates the BPF-side allow tables that the FILTER_*
+// blocks compiled into the probe source consult. Safe to call with a
+// zero-value cfg -- the generated source won't have compiled in any
+// checks, so there's nothing to populate.
+func applyFilters(m *bpf.Module, cfg filterConfig) error {
+	if len(cfg.pids) > 0 {
+		t := bpf.NewTable(m.TableId("allowed_pids"), m)
+		for _, pid := range cfg.pids {
+			key := make([]byte, 4)
+			binary.LittleEndian.PutUint32(key, uint32(pid))
+			if err := t.Set(key, []byte{1}); err != nil {
+				return fmt.Errorf("allowing pid %d: %w", pid, err)
+			}
+		}
+	}
+
+	if cfg.cgroupPath != "" {
+		id, err := cgroupID(cfg.cgroupPath)
+		if err != nil {
+			return err
+		}
+		t := bpf.NewTable(m.TableId("allowed_cgroups"), m)
+		key := make([]byte, 8)
+		binary.LittleEndian.PutUint64(key, id)
+		if err := t.Set(key, []byte{1}); err != nil {
+			return fmt.Errorf("allowing cgroup %s: %w", cfg.cgroupPath, err)
+		}
+	}
+
+	if cfg.comm != "" {
+		t := bpf.NewTable(m.TableId("allowed_comm"), m)
+		key := make([]byte, 16)
+		copy(key, cfg.comm)
+		if err := t.Set(key, []byte{1}); err != nil {
+			return fmt.Errorf("allowing comm %s: %w", cfg.comm, err)
+		}
+	}
+
+	return nil
+}
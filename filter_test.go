@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePIDList(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []int
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"1", []int{1}, false},
+		{"1,2,3", []int{1, 2, 3}, false},
+		{"1, 2 , 3", []int{1, 2, 3}, false},
+		{"abc", nil, true},
+		{"1,,2", nil, true},
+	}
+	for _, tc := range tests {
+		got, err := parsePIDList(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parsePIDList(%q) = %v, want error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePIDList(%q) returned unexpected error: %s", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parsePIDList(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
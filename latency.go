@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+
+	bpf "github.com/iovisor/gobpf/bcc"
+)
+
+// latencySourceTemplate is a BCC funclatency-style probe pair: the entry
+// probe stashes the call's start time and request info keyed by
+// pid_tgid, and the return probe looks both up, computes the elapsed
+// time and increments a per-route log2 histogram bucket.
+//
+// KNOWN LIMITATION: like buildSource's trace probes, start/reqinfo are
+// keyed by pid_tgid, the OS thread ID -- not anything tied to the Go
+// goroutine actually running the handler. If the handler blocks (reads
+// the request body, waits on a downstream call, flushes a large
+// response), the Go scheduler can resume it on a different thread by the
+// time handler_return fires, the lookup misses, and that call is
+// silently excluded from the histogram rather than recorded as slow.
+// There's no pid_tgid-independent key available here either.
+//
+// %[1]d/%[2]d/%[3]d gate the compiled-in pid/cgroup/comm filter checks,
+// mirroring buildSource. %[4]d/%[5]d/%[6]d are the net/http.Request and
+// net/url.URL field byte offsets, also resolved via
+// resolveRequestFieldOffsets. %[7]s is the ABI-specific snippet that
+// loads the *http.Request pointer into `req`.
+const latencySourceTemplate = `
+#include <uapi/linux/ptrace.h>
+
+#define OFFSET(ptr, offset) (void*)ptr + offset * 8
+#define FIELD(ptr, off) (void*)((char*)(ptr) + (off))
+
+#define FILTER_PID     %[1]d
+#define FILTER_CGROUP  %[2]d
+#define FILTER_COMM    %[3]d
+
+#define OFF_METHOD   %[4]d
+#define OFF_URL      %[5]d
+#define OFF_URL_PATH %[6]d
+
+struct info_t {
+	u64  method_len;
+	u64  path_len;
+	char method[10];
+	char path[128];
+};
+
+struct hist_key_t {
+	char method[10];
+	char path[128];
+	u64  slot;
+} __attribute__((packed));
+struct comm_key_t {
+	char comm[16];
+};
+
+BPF_HASH(start, u64, u64);
+BPF_HASH(reqinfo, u64, struct info_t);
+BPF_HISTOGRAM(dist, struct hist_key_t);
+BPF_HASH(allowed_pids, u32, u8);
+BPF_HASH(allowed_cgroups, u64, u8);
+BPF_HASH(allowed_comm, struct comm_key_t, u8);
+
+static inline int filtered_out()
+{
+#if FILTER_PID
+	u32 pid = bpf_get_current_pid_tgid() >> 32;
+	if (allowed_pids.lookup(&pid) == 0)
+		return 1;
+#endif
+#if FILTER_CGROUP
+	u64 cgroup = bpf_get_current_cgroup_id();
+	if (allowed_cgroups.lookup(&cgroup) == 0)
+		return 1;
+#endif
+#if FILTER_COMM
+	struct comm_key_t key = {};
+	bpf_get_current_comm(&key.comm, sizeof(key.comm));
+	if (allowed_comm.lookup(&key) == 0)
+		return 1;
+#endif
+	return 0;
+}
+
+int handler_entry(struct pt_regs *ctx)
+{
+	if (filtered_out())
+		return 0;
+
+	u64 pid_tgid = bpf_get_current_pid_tgid();
+	u64 ts = bpf_ktime_get_ns();
+	start.update(&pid_tgid, &ts);
+
+	struct info_t info = {};
+%[7]s
+	u64 data;
+
+	// method
+	bpf_probe_read(&data, sizeof(data), FIELD(req, OFF_METHOD));
+	bpf_probe_read(&info.method_len, sizeof(info.method_len), FIELD(req, OFF_METHOD + 8));
+	bpf_probe_read(&info.method,
+		info.method_len > sizeof(info.method) ? sizeof(info.method) : info.method_len,
+		(void*)data);
+
+	// path
+	u64 url;
+	bpf_probe_read(&url, sizeof(url), FIELD(req, OFF_URL));
+	bpf_probe_read(&data, sizeof(data), FIELD(url, OFF_URL_PATH));
+	bpf_probe_read(&info.path_len, sizeof(info.path_len), FIELD(url, OFF_URL_PATH + 8));
+	bpf_probe_read(&info.path,
+		info.path_len > sizeof(info.path) ? sizeof(info.path) : info.path_len,
+		(void*)data);
+
+	reqinfo.update(&pid_tgid, &info);
+	return 0;
+}
+
+int handler_return(struct pt_regs *ctx)
+{
+	u64 pid_tgid = bpf_get_current_pid_tgid();
+
+	u64 *tsp = start.lookup(&pid_tgid);
+	if (tsp == 0)
+		return 0;
+
+	struct info_t *info = reqinfo.lookup(&pid_tgid);
+	if (info == 0) {
+		start.delete(&pid_tgid);
+		return 0;
+	}
+
+	u64 delta = bpf_ktime_get_ns() - *tsp;
+
+	struct hist_key_t key = {};
+	__builtin_memcpy(&key.method, &info->method, sizeof(key.method));
+	__builtin_memcpy(&key.path, &info->path, sizeof(key.path));
+	key.slot = bpf_log2l(delta);
+	dist.increment(key);
+
+	start.delete(&pid_tgid);
+	reqinfo.delete(&pid_tgid);
+	return 0;
+}
+`
+
+const reqFromStackABI = `	u64 req;
+	bpf_probe_read(&req, sizeof(req), OFFSET(PT_REGS_SP(ctx), 3));
+`
+
+const reqFromRegisterABIAmd64 = `	u64 req = ctx->cx;
+`
+
+const reqFromRegisterABIArm64 = `	u64 req = ctx->regs[2];
+`
+
+// buildLatencySource assembles the latency probe pair for the target's
+// calling convention, architecture and request-field offsets, compiling
+// in the filter checks that cfg requires.
+func buildLatencySource(a abi, goarch string, cfg filterConfig, off *requestFieldOffsets) (string, error) {
+	pidFilter, cgroupFilter, commFilter := 0, 0, 0
+	if len(cfg.pids) > 0 {
+		pidFilter = 1
+	}
+	if cfg.cgroupPath != "" {
+		cgroupFilter = 1
+	}
+	if cfg.comm != "" {
+		commFilter = 1
+	}
+
+	var reqSnip string
+	if a == abiStack {
+		reqSnip = reqFromStackABI
+	} else {
+		switch goarch {
+		case "amd64":
+			reqSnip = reqFromRegisterABIAmd64
+		case "arm64":
+			reqSnip = reqFromRegisterABIArm64
+		default:
+			return "", fmt.Errorf("register ABI probe not implemented for GOARCH=%s", goarch)
+		}
+	}
+
+	return fmt.Sprintf(latencySourceTemplate,
+		pidFilter, cgroupFilter, commFilter,
+		off.Method, off.URL, off.URLPath,
+		reqSnip), nil
+}
+
+// histKey mirrors struct hist_key_t above.
+type histKey struct {
+	Method [10]byte
+	Path   [128]byte
+	Slot   uint64
+}
+
+// runLatency attaches a uprobe/uretprobe pair to symbol in every target
+// and, on SIGINT, prints a per-route log2 latency histogram in the style
+// of BCC's funclatency. See runTrace for the multi-target and cgroup
+// caveats, which apply here too.
+//
+// DANGER: a uretprobe works by patching the function's return address on
+// the goroutine's stack. Go moves goroutine stacks on growth (and copies
+// them when doing so), which can leave that patched address pointing at
+// stale or unmapped memory and crash the traced process outright. This
+// is a real risk against production Go servers, not a theoretical one --
+// callers must pass confirmUretprobeRisk to acknowledge it.
+func runLatency(targets []target, cfg filterConfig, confirmUretprobeRisk bool) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("no targets to attach to")
+	}
+	if !confirmUretprobeRisk {
+		return fmt.Errorf("-mode latency attaches a uretprobe, which can crash the target if Go grows/copies its stack mid-call; pass -i-accept-uretprobe-risk to proceed")
+	}
+	first := targets[0]
+
+	a, err := detectABI(first.Bin)
+	if err != nil {
+		return fmt.Errorf("could not detect calling convention of %s: %w", first.Bin, err)
+	}
+
+	off, err := resolveRequestFieldOffsets(first.Bin)
+	if err != nil {
+		return fmt.Errorf("could not resolve request field offsets for %s: %w", first.Bin, err)
+	}
+
+	source, err := buildLatencySource(a, runtime.GOARCH, cfg, off)
+	if err != nil {
+		return fmt.Errorf("could not build probe: %w", err)
+	}
+
+	m := bpf.NewModule(source, []string{})
+	defer m.Close()
+
+	if err := applyFilters(m, cfg); err != nil {
+		return fmt.Errorf("could not apply filters: %w", err)
+	}
+
+	entry, err := m.LoadKprobe("handler_entry")
+	if err != nil {
+		return fmt.Errorf("failed to load entry probe: %w", err)
+	}
+	ret, err := m.LoadKprobe("handler_return")
+	if err != nil {
+		return fmt.Errorf("failed to load return probe: %w", err)
+	}
+
+	attached := map[string]bool{}
+	attach := func(t target) error {
+		if attached[t.Bin] {
+			return nil
+		}
+		if err := m.AttachUprobe(t.Bin, t.Symbol, entry, -1); err != nil {
+			return fmt.Errorf("could not attach uprobe to %s in %s: %w", t.Symbol, t.Bin, err)
+		}
+		if err := m.AttachUretprobe(t.Bin, t.Symbol, ret, -1); err != nil {
+			return fmt.Errorf("could not attach uretprobe to %s in %s: %w", t.Symbol, t.Bin, err)
+		}
+		attached[t.Bin] = true
+		return nil
+	}
+
+	for _, t := range targets {
+		if err := attach(t); err != nil {
+			return err
+		}
+	}
+
+	if cfg.cgroupPath != "" {
+		err := watchCgroup(cfg.cgroupPath, func() {
+			newTargets, err := discoverTargets(cfg.cgroupPath, first.Symbol)
+			if err != nil {
+				return
+			}
+			for _, t := range newTargets {
+				if err := attach(t); err != nil {
+					fmt.Printf("failed to attach to new binary %s: %s\n", t.Bin, err)
+				}
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("could not watch cgroup %s: %w", cfg.cgroupPath, err)
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, os.Kill)
+	<-sig
+
+	printLatencyHistogram(bpf.NewTable(m.TableId("dist"), m))
+	return nil
+}
+
+// printLatencyHistogram renders the dist table as a per-route log2 bucket
+// chart, in nanosecond buckets (slot covers 2^(slot-1) to 2^slot ns, from
+// bpf_ktime_get_ns()).
+func printLatencyHistogram(table *bpf.Table) {
+	type bucket struct {
+		route string
+		slot  uint64
+		count uint64
+	}
+
+	var buckets []bucket
+	for it := table.Iter(); it.Next(); {
+		var key histKey
+		if err := binary.Read(bytes.NewBuffer(it.Key()), bpf.GetHostByteOrder(), &key); err != nil {
+			continue
+		}
+		count := bpf.GetHostByteOrder().Uint64(it.Leaf())
+		methodLen, pathLen := cstrLen(key.Method[:]), cstrLen(key.Path[:])
+		route := fmt.Sprintf("%s %s", key.Method[:methodLen], key.Path[:pathLen])
+		buckets = append(buckets, bucket{route: route, slot: key.Slot, count: count})
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].route != buckets[j].route {
+			return buckets[i].route < buckets[j].route
+		}
+		return buckets[i].slot < buckets[j].slot
+	})
+
+	last := ""
+	for _, b := range buckets {
+		if b.route != last {
+			fmt.Printf("\n%s\n", b.route)
+			last = b.route
+		}
+		lo, hi := uint64(1)<<b.slot>>1, uint64(1)<<b.slot
+		fmt.Printf("  %8d ns -> %-8d ns : %d\n", lo, hi, b.count)
+	}
+}
+
+// cstrLen returns the length of the NUL-terminated string in b, or len(b)
+// if it isn't terminated.
+func cstrLen(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
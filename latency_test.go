@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestCstrLen(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		want int
+	}{
+		{[]byte("GET"), 3},
+		{[]byte("GET\x00\x00"), 3},
+		{[]byte("\x00\x00\x00"), 0},
+		{[]byte{}, 0},
+		{make([]byte, 10), 0}, // zero-valued slice: NUL at index 0
+	}
+	for _, tc := range tests {
+		if got := cstrLen(tc.b); got != tc.want {
+			t.Errorf("cstrLen(%q) = %d, want %d", tc.b, got, tc.want)
+		}
+	}
+}
@@ -1,110 +1,77 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
+	"flag"
 	"log"
-	"os"
-	"os/signal"
-
-	bpf "github.com/iovisor/gobpf/bcc"
 )
 
-const source = `
-#include <uapi/linux/ptrace.h>
-
-#define OFFSET(ptr, offset) (void*)ptr + offset * 8
-
-struct event {
-	u64  method_len;
-	u64  path_len;
-	char method[10];
-	char path[128];
-};
-BPF_PERF_OUTPUT(events);
-
-int handler(struct pt_regs *ctx)
-{
-	struct event e = {};
-
-	u64 req;
-	bpf_probe_read(&req, sizeof(req), OFFSET(PT_REGS_SP(ctx), 3));
-
-	u64 data;
+// headerFlags collects repeated -header flags in the order they're given.
+type headerFlags []string
 
-	// method
-	bpf_probe_read(&data, sizeof(data), OFFSET(req, 0));
-	bpf_probe_read(&e.method_len, sizeof(e.method_len), OFFSET(req, 1));
-
-	bpf_probe_read(&e.method,
-		e.method_len > sizeof(e.method) ? sizeof(e.method) : e.method_len,
-		(void*)data);
-
-	// path
-	u64 url;
-	bpf_probe_read(&url, sizeof(url), OFFSET(req, 2));
-	bpf_probe_read(&data, sizeof(data), OFFSET(url, 7));
-	bpf_probe_read(&e.path_len, sizeof(e.path_len), OFFSET(url, 8));
-
-	bpf_probe_read(&e.path,
-		e.path_len > sizeof(e.path) ? sizeof(e.path) : e.path_len,
-		(void*)data);
-
-	// emit event
-	events.perf_submit(ctx, &e, sizeof(e));
-
-	return 0;
-}
-`
+func (h *headerFlags) String() string { return "" }
 
-type event struct {
-	MethodLen uint64
-	PathLen   uint64
-	Method    [10]byte
-	Path      [128]byte
+func (h *headerFlags) Set(v string) error {
+	*h = append(*h, v)
+	return nil
 }
 
 func main() {
-	m := bpf.NewModule(source, []string{})
-	defer m.Close()
-
-	uprobe, err := m.LoadKprobe("handler")
+	mode := flag.String("mode", "trace", "operation mode: trace (per-request log) or latency (funclatency-style histogram)")
+	pidFlag := flag.Int("pid", 0, "only capture calls from this PID (filter only -- does not attach to or discover the PID's children; use -cgroup-path for multi-binary attach)")
+	pidsFlag := flag.String("pids", "", "only capture calls from these comma-separated PIDs (filter only, see -pid)")
+	cgroupPath := flag.String("cgroup-path", "", "attach to every Go binary running in this cgroup, and any that join it later")
+	comm := flag.String("comm", "", "only capture calls from processes with this comm")
+	output := flag.String("output", "text", "trace mode output format: text or json (NDJSON on stdout)")
+	promListen := flag.String("prom-listen", "", "trace mode only: if set, serve Prometheus metrics on this address (e.g. :9100)")
+	acceptUretprobeRisk := flag.Bool("i-accept-uretprobe-risk", false, "required by -mode latency: uretprobes patch a goroutine's return address, and Go's stack growth/copying can corrupt that patch and crash the target process")
+	var headers headerFlags
+	flag.Var(&headers, "header", "request header to capture in trace mode (repeatable, e.g. -header Authorization); best-effort, capped at 4")
+	flag.Parse()
+
+	pids, err := parsePIDList(*pidsFlag)
 	if err != nil {
-		log.Fatalf("Failed to load kprobe: %s\n", err)
+		log.Fatal(err)
 	}
-
-	err = m.AttachUprobe(os.Args[1], os.Args[2], uprobe, -1)
-	if err != nil {
-		log.Fatalf("could not attach uprobe to symbol: %s: %s", os.Args[2], err.Error())
+	if *pidFlag != 0 {
+		pids = append(pids, *pidFlag)
 	}
+	cfg := filterConfig{pids: pids, cgroupPath: *cgroupPath, comm: *comm}
 
-	table := bpf.NewTable(m.TableId("events"), m)
-	channel := make(chan []byte, 100)
+	args := flag.Args()
 
-	perfMap, err := bpf.InitPerfMap(table, channel, nil)
+	var targets []target
+	if *cgroupPath != "" {
+		if len(args) != 1 {
+			log.Fatalf("usage: %s [flags] --cgroup-path=<path> <symbol>", flag.CommandLine.Name())
+		}
+		targets, err = discoverTargets(*cgroupPath, args[0])
+	} else {
+		if len(args) != 2 {
+			log.Fatalf("usage: %s [flags] <binary> <symbol>", flag.CommandLine.Name())
+		}
+		targets = []target{{Bin: args[0], Symbol: args[1]}}
+	}
 	if err != nil {
-		log.Fatalf("Failed to init perf map: %s\n", err)
+		log.Fatal(err)
 	}
 
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, os.Kill)
-
-	go func() {
-		var e event
-		for {
-			data := <-channel
-			if err := binary.Read(bytes.NewBuffer(data), bpf.GetHostByteOrder(), &e); err != nil {
-				fmt.Printf("failed to decode packet: %s\n", err)
-				continue
+	switch *mode {
+	case "trace":
+		var p printer
+		p, err = newPrinter(*output)
+		if err == nil {
+			if *promListen != "" {
+				p = promPrinter{next: p}
+				servePromMetrics(*promListen)
 			}
-			method, path := string(e.Method[:e.MethodLen]), string(e.Path[:e.PathLen])
-			fmt.Printf("%-10s %s\n", method, path)
+			err = runTrace(targets, cfg, headers, p)
 		}
-	}()
-	fmt.Printf("%-10s %s\n", "Method", "Path")
-
-	perfMap.Start()
-	<-sig
-	perfMap.Stop()
+	case "latency":
+		err = runLatency(targets, cfg, *acceptUretprobeRisk)
+	default:
+		log.Fatalf("unknown -mode %q", *mode)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// WARNING: path is the raw request path, unnormalized. On any route with
+// an ID or other variable segment in it (/users/123, /users/124, ...)
+// this makes path an unbounded label and these metrics an unbounded
+// cardinality time series -- against a real service this will grow
+// without limit and can OOM the scrape target (Prometheus or whatever's
+// fronting it). Front this with a path-normalizing relabel (e.g. collapse
+// path segments that look like IDs) before pointing it at production
+// traffic; there's no such normalization here.
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total HTTP requests observed via eBPF, labeled by method, path and status.",
+		},
+		[]string{"method", "path", "status"},
+	)
+	httpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request duration observed via eBPF.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"method", "path"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(httpRequestsTotal, httpRequestDuration)
+}
+
+// promPrinter feeds decoded events into the Prometheus collectors above,
+// then delegates to next -- -prom-listen is additive to -output, not a
+// replacement for it.
+type promPrinter struct {
+	next printer
+}
+
+func (p promPrinter) print(e event, headers []string) {
+	method := decodeCStr(e.Method[:], e.MethodLen)
+	path := decodeCStr(e.Path[:], e.PathLen)
+	status := strconv.Itoa(int(e.Status))
+
+	httpRequestsTotal.WithLabelValues(method, path, status).Inc()
+	httpRequestDuration.WithLabelValues(method, path).Observe(float64(e.LatencyNs) / 1e9)
+
+	p.next.print(e, headers)
+}
+
+// servePromMetrics starts the /metrics endpoint on addr in the
+// background. A failure to bind is reported but not fatal: it shouldn't
+// take down tracing just because the metrics port is already in use.
+func servePromMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "prometheus listener on %s stopped: %s\n", addr, err)
+		}
+	}()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+)
+
+// structOffsets maps a struct's field names to their byte offset, as
+// reported by the compiler's own DWARF debug info. This replaces the
+// hand-counted "* 8" slot arithmetic formerly used in the probe source for
+// http.Request/url.URL, which broke every time the Go team reordered a
+// struct field between releases -- these offsets are read straight from
+// the layout the compiler that built the target actually emitted.
+type structOffsets map[string]int64
+
+// resolveStructOffsets looks up structName (e.g. "net/http.Request") in
+// binPath's DWARF info and returns the byte offset of each of its
+// fields. It fails if the binary was built with debug info stripped
+// (`-ldflags -w`), same as any other DWARF-based tool.
+func resolveStructOffsets(binPath, structName string) (structOffsets, error) {
+	f, err := elf.Open(binPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", binPath, err)
+	}
+	defer f.Close()
+
+	d, err := f.DWARF()
+	if err != nil {
+		return nil, fmt.Errorf("reading DWARF from %s: %w (binary built with -ldflags -w?)", binPath, err)
+	}
+
+	r := d.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return nil, fmt.Errorf("reading DWARF entries: %w", err)
+		}
+		if entry == nil {
+			break
+		}
+		if entry.Tag != dwarf.TagStructType {
+			continue
+		}
+		if name, _ := entry.Val(dwarf.AttrName).(string); name == structName {
+			return readMemberOffsets(r)
+		}
+	}
+	return nil, fmt.Errorf("struct %s not found in %s's DWARF info", structName, binPath)
+}
+
+// readMemberOffsets reads the TagMember children immediately following
+// the struct entry the caller's reader is positioned on.
+func readMemberOffsets(r *dwarf.Reader) (structOffsets, error) {
+	offsets := structOffsets{}
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil || entry.Tag != dwarf.TagMember {
+			break
+		}
+		name, _ := entry.Val(dwarf.AttrName).(string)
+		loc, ok := entry.Val(dwarf.AttrDataMemberLoc).(int64)
+		if !ok || name == "" {
+			continue
+		}
+		offsets[name] = loc
+	}
+	return offsets, nil
+}
+
+// get returns the offset of field, or an error naming both the field and
+// struct so a version mismatch is easy to diagnose.
+func (o structOffsets) get(structName, field string) (int64, error) {
+	off, ok := o[field]
+	if !ok {
+		return 0, fmt.Errorf("field %s.%s not found (unexpected Go version?)", structName, field)
+	}
+	return off, nil
+}
+
+// requestFieldOffsets holds the byte offsets gohttpsnoop's probes read
+// out of net/http.Request, net/url.URL and net/http.response.
+type requestFieldOffsets struct {
+	Method     int64
+	URL        int64
+	Host       int64
+	RemoteAddr int64
+	Proto      int64
+	Header     int64
+	URLPath    int64
+	Written    int64
+}
+
+// resolveRequestFieldOffsets computes requestFieldOffsets for the Go
+// version binPath was built with.
+func resolveRequestFieldOffsets(binPath string) (*requestFieldOffsets, error) {
+	req, err := resolveStructOffsets(binPath, "net/http.Request")
+	if err != nil {
+		return nil, err
+	}
+	url, err := resolveStructOffsets(binPath, "net/url.URL")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := resolveStructOffsets(binPath, "net/http.response")
+	if err != nil {
+		return nil, err
+	}
+
+	var o requestFieldOffsets
+	var errs []error
+	get := func(so structOffsets, structName, field string, dst *int64) {
+		v, err := so.get(structName, field)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		*dst = v
+	}
+	get(req, "http.Request", "Method", &o.Method)
+	get(req, "http.Request", "URL", &o.URL)
+	get(req, "http.Request", "Host", &o.Host)
+	get(req, "http.Request", "RemoteAddr", &o.RemoteAddr)
+	get(req, "http.Request", "Proto", &o.Proto)
+	get(req, "http.Request", "Header", &o.Header)
+	get(url, "url.URL", "Path", &o.URLPath)
+	get(resp, "http.response", "written", &o.Written)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return &o, nil
+}
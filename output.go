@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// record is one line emitted by -output json: a self-contained
+// description of a single served request, meant for downstream tools
+// like jq, vector or Loki.
+type record struct {
+	Ts         int64             `json:"ts"`
+	Pid        uint32            `json:"pid"`
+	Comm       string            `json:"comm"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Host       string            `json:"host"`
+	RemoteAddr string            `json:"remote_addr"`
+	Proto      string            `json:"proto"`
+	Status     int32             `json:"status"`
+	LatencyNs  uint64            `json:"latency_ns"`
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+// printer renders decoded trace events in whichever format -output
+// selects. headers names the -header values e carries, in order.
+type printer interface {
+	print(e event, headers []string)
+}
+
+// newPrinter builds the printer for -output, printing a header line for
+// the human-readable text format up front.
+func newPrinter(output string) (printer, error) {
+	switch output {
+	case "", "text":
+		fmt.Printf("%-10s %-30s %-3s %s\n", "Method", "Path", "Status", "Bytes")
+		return textPrinter{}, nil
+	case "json":
+		return jsonPrinter{enc: json.NewEncoder(os.Stdout)}, nil
+	default:
+		return nil, fmt.Errorf("unknown -output %q", output)
+	}
+}
+
+type textPrinter struct{}
+
+func (textPrinter) print(e event, headers []string) {
+	method, path := decodeCStr(e.Method[:], e.MethodLen), decodeCStr(e.Path[:], e.PathLen)
+	fmt.Printf("%-10s %-30s %-3d %d\n", method, path, e.Status, e.Written)
+	for name, value := range e.headers(headers) {
+		fmt.Printf("  %s: %s\n", name, value)
+	}
+}
+
+type jsonPrinter struct {
+	enc *json.Encoder
+}
+
+func (p jsonPrinter) print(e event, headers []string) {
+	r := record{
+		Ts:         time.Now().UnixNano(), // receive time, not exact kernel capture time
+		Pid:        e.Pid,
+		Comm:       decodeCStr(e.Comm[:], uint64(cstrLen(e.Comm[:]))),
+		Method:     decodeCStr(e.Method[:], e.MethodLen),
+		Path:       decodeCStr(e.Path[:], e.PathLen),
+		Host:       decodeCStr(e.Host[:], e.HostLen),
+		RemoteAddr: decodeCStr(e.RemoteAddr[:], e.RemoteAddrLen),
+		Proto:      decodeCStr(e.Proto[:], e.ProtoLen),
+		Status:     e.Status,
+		LatencyNs:  e.LatencyNs,
+		Headers:    e.headers(headers),
+	}
+	if err := p.enc.Encode(r); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode record: %s\n", err)
+	}
+}
+
+// decodeCStr trims b to its len-prefixed length n.
+func decodeCStr(b []byte, n uint64) string {
+	if n > uint64(len(b)) {
+		n = uint64(len(b))
+	}
+	return string(b[:n])
+}
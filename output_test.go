@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestDecodeCStr(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		n    uint64
+		want string
+	}{
+		{[]byte("GET"), 3, "GET"},
+		{[]byte("GET\x00\x00"), 3, "GET"},
+		{[]byte("GET"), 0, ""},
+		{[]byte("GET"), 100, "GET"}, // n past len(b) clamps to len(b)
+		{[]byte{}, 5, ""},
+	}
+	for _, tc := range tests {
+		if got := decodeCStr(tc.b, tc.n); got != tc.want {
+			t.Errorf("decodeCStr(%q, %d) = %q, want %q", tc.b, tc.n, got, tc.want)
+		}
+	}
+}
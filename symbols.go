@@ -0,0 +1,51 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+)
+
+// symTable resolves Go function names to metadata using the binary's
+// embedded pclntab -- the same data the runtime itself uses to produce
+// stack traces -- so probes can be attached to internal stdlib functions
+// without the user having to pass symbol names or offsets on the command
+// line, and so we can fail with a clear error if a target's Go version
+// renamed or inlined a function we depend on.
+type symTable struct {
+	tab *gosym.Table
+}
+
+func newSymTable(binPath string) (*symTable, error) {
+	f, err := elf.Open(binPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", binPath, err)
+	}
+	defer f.Close()
+
+	text := f.Section(".text")
+	if text == nil {
+		return nil, fmt.Errorf("%s has no .text section", binPath)
+	}
+
+	pclntabSection := f.Section(".gopclntab")
+	if pclntabSection == nil {
+		return nil, fmt.Errorf("%s has no .gopclntab section (not a Go binary?)", binPath)
+	}
+	pclntab, err := pclntabSection.Data()
+	if err != nil {
+		return nil, fmt.Errorf("reading .gopclntab: %w", err)
+	}
+
+	lineTab := gosym.NewLineTable(pclntab, text.Addr)
+	tab, err := gosym.NewTable(nil, lineTab)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Go symbol table: %w", err)
+	}
+	return &symTable{tab: tab}, nil
+}
+
+// Has reports whether name exists as a function in the binary.
+func (s *symTable) Has(name string) bool {
+	return s.tab.LookupFunc(name) != nil
+}
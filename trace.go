@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+
+	bpf "github.com/iovisor/gobpf/bcc"
+)
+
+type event struct {
+	MethodLen     uint64
+	PathLen       uint64
+	Method        [10]byte
+	Path          [128]byte
+	Host          [64]byte
+	HostLen       uint64
+	RemoteAddr    [64]byte
+	RemoteAddrLen uint64
+	Proto         [16]byte
+	ProtoLen      uint64
+	Header0       [128]byte
+	Header0Len    uint64
+	Header1       [128]byte
+	Header1Len    uint64
+	Header2       [128]byte
+	Header2Len    uint64
+	Header3       [128]byte
+	Header3Len    uint64
+	Comm          [16]byte
+	Pid           uint32
+	Status        int32
+	StartNs       uint64
+	LatencyNs     uint64
+	Written       int64
+}
+
+// headers returns the captured -header values keyed by header name, the
+// i-th name mapped to the i-th captured slot. The result is a map, so it
+// carries no order.
+func (e event) headers(names []string) map[string]string {
+	bufs := [][]byte{e.Header0[:], e.Header1[:], e.Header2[:], e.Header3[:]}
+	lens := []uint64{e.Header0Len, e.Header1Len, e.Header2Len, e.Header3Len}
+
+	m := make(map[string]string, len(names))
+	for i, name := range names {
+		if i >= len(bufs) {
+			break
+		}
+		m[name] = decodeCStr(bufs[i], lens[i])
+	}
+	return m
+}
+
+// runTrace attaches a uprobe to symbol in every target, plus uprobes on
+// the net/http internals that report response status and bytes written,
+// and prints one consolidated line per request. All targets are assumed
+// to share a Go build (and therefore ABI and struct layout) -- attaching
+// to binaries built with different Go versions at once isn't supported.
+//
+// If cfg.cgroupPath is set, targets is treated as a starting snapshot:
+// runTrace keeps watching the cgroup and attaches to any new binaries
+// that join it. headers names the -header values to capture, in order.
+func runTrace(targets []target, cfg filterConfig, headers []string, p printer) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("no targets to attach to")
+	}
+	first := targets[0]
+
+	a, err := detectABI(first.Bin)
+	if err != nil {
+		return fmt.Errorf("could not detect calling convention of %s: %w", first.Bin, err)
+	}
+
+	off, err := resolveRequestFieldOffsets(first.Bin)
+	if err != nil {
+		return fmt.Errorf("could not resolve request field offsets for %s: %w", first.Bin, err)
+	}
+
+	source, err := buildSource(a, runtime.GOARCH, cfg, off, headers)
+	if err != nil {
+		return fmt.Errorf("could not build probe: %w", err)
+	}
+
+	m := bpf.NewModule(source, []string{})
+	defer m.Close()
+
+	if err := applyFilters(m, cfg); err != nil {
+		return fmt.Errorf("could not apply filters: %w", err)
+	}
+
+	handler, err := m.LoadKprobe("handler")
+	if err != nil {
+		return fmt.Errorf("failed to load handler probe: %w", err)
+	}
+	onWriteHeader, err := m.LoadKprobe("on_write_header")
+	if err != nil {
+		return fmt.Errorf("failed to load WriteHeader probe: %w", err)
+	}
+	onFinishRequest, err := m.LoadKprobe("on_finish_request")
+	if err != nil {
+		return fmt.Errorf("failed to load finishRequest probe: %w", err)
+	}
+
+	attached := map[string]bool{}
+	attach := func(t target) error {
+		if attached[t.Bin] {
+			return nil
+		}
+		syms, err := newSymTable(t.Bin)
+		if err != nil {
+			return fmt.Errorf("could not read symbol table of %s: %w", t.Bin, err)
+		}
+		for _, name := range []string{writeHeaderSymbol, finishRequestSymbol} {
+			if !syms.Has(name) {
+				return fmt.Errorf("symbol %s not found in %s (unexpected net/http version?)", name, t.Bin)
+			}
+		}
+		if err := m.AttachUprobe(t.Bin, t.Symbol, handler, -1); err != nil {
+			return fmt.Errorf("could not attach uprobe to %s in %s: %w", t.Symbol, t.Bin, err)
+		}
+		if err := m.AttachUprobe(t.Bin, writeHeaderSymbol, onWriteHeader, -1); err != nil {
+			return fmt.Errorf("could not attach uprobe to %s in %s: %w", writeHeaderSymbol, t.Bin, err)
+		}
+		if err := m.AttachUprobe(t.Bin, finishRequestSymbol, onFinishRequest, -1); err != nil {
+			return fmt.Errorf("could not attach uprobe to %s in %s: %w", finishRequestSymbol, t.Bin, err)
+		}
+		attached[t.Bin] = true
+		return nil
+	}
+
+	for _, t := range targets {
+		if err := attach(t); err != nil {
+			return err
+		}
+	}
+
+	if cfg.cgroupPath != "" {
+		err := watchCgroup(cfg.cgroupPath, func() {
+			newTargets, err := discoverTargets(cfg.cgroupPath, first.Symbol)
+			if err != nil {
+				return
+			}
+			for _, t := range newTargets {
+				if err := attach(t); err != nil {
+					fmt.Printf("failed to attach to new binary %s: %s\n", t.Bin, err)
+				}
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("could not watch cgroup %s: %w", cfg.cgroupPath, err)
+		}
+	}
+
+	table := bpf.NewTable(m.TableId("events"), m)
+	channel := make(chan []byte, 100)
+
+	perfMap, err := bpf.InitPerfMap(table, channel, nil)
+	if err != nil {
+		return fmt.Errorf("failed to init perf map: %w", err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, os.Kill)
+
+	go func() {
+		var e event
+		for {
+			data := <-channel
+			if err := binary.Read(bytes.NewBuffer(data), bpf.GetHostByteOrder(), &e); err != nil {
+				fmt.Printf("failed to decode packet: %s\n", err)
+				continue
+			}
+			p.print(e, headers)
+		}
+	}()
+
+	perfMap.Start()
+	<-sig
+	perfMap.Stop()
+
+	return nil
+}